@@ -0,0 +1,209 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/benchmark/parse"
+)
+
+var (
+	oCompare   = flag.String("compare", "", "Compare two revisions, revA..revB, benchmark by benchmark, instead of running the current tree once.")
+	oWorkdir   = flag.String("workdir", "", "Scratch directory for the git worktrees used by -compare (default: a temporary directory, removed afterwards).")
+	oArtifacts = flag.String("artifacts", "", "Directory to collect -cpuprofile/-memprofile/-trace of each -compare run, under <dir>/<rev>/<benchmark>.{cpu,mem,trace}.")
+)
+
+// runCompare implements -compare: it builds and benchmarks importPath under
+// two git revisions, each checked out into its own scratch worktree, and
+// prints a benchcmp-style delta table, replacing the old workflow of saving
+// two raw runs and feeding them to benchcmp by hand.
+func runCompare(importPath string) {
+	revA, revB := splitRevs(*oCompare)
+
+	repoRoot, err := gitOutput("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		log.Fatalf("-compare requires running inside a git checkout: %s", err)
+	}
+
+	workdir := *oWorkdir
+	if workdir == "" {
+		workdir, err = ioutil.TempDir("", "bench-compare")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(workdir)
+	}
+
+	gopath := map[string]string{}
+	dest := map[string]string{}
+	for _, rev := range []string{revA, revB} {
+		commit, err := gitOutput(repoRoot, "rev-parse", rev)
+		if err != nil {
+			log.Fatalf("git rev-parse %s: %s", rev, err)
+		}
+
+		gp := filepath.Join(workdir, sanitizeRev(rev))
+		d := filepath.Join(gp, "src", importPath)
+		if err := os.MkdirAll(filepath.Dir(d), 0755); err != nil {
+			log.Fatal(err)
+		}
+		// --detach and a resolved commit, rather than the rev itself, let
+		// -compare check out a branch that is already checked out in the
+		// primary worktree (e.g. comparing HEAD against the branch you're
+		// standing on), which a plain branch checkout would refuse.
+		if _, err := gitOutput(repoRoot, "worktree", "add", "--detach", d, commit); err != nil {
+			log.Fatalf("git worktree add %s %s: %s", d, rev, err)
+		}
+		gopath[rev] = gp
+		dest[rev] = d
+	}
+	defer func() {
+		for _, rev := range []string{revA, revB} {
+			if _, err := gitOutput(repoRoot, "worktree", "remove", "--force", dest[rev]); err != nil {
+				log.Printf("git worktree remove %s: %s", dest[rev], err)
+			}
+		}
+	}()
+
+	bench, err := discoverBenchmarks([]string{gopath[revA]}, importPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := make(map[string]map[string][]*parse.Benchmark, len(bench))
+	for i, name := range bench {
+		if i > 0 {
+			cooldown()
+		}
+		old := runIsolatedInGopath(name, importPath, gopath[revA], revA)
+		cooldown()
+		results[name] = map[string][]*parse.Benchmark{
+			revA: old,
+			revB: runIsolatedInGopath(name, importPath, gopath[revB], revB),
+		}
+	}
+
+	printCompareTable(bench, revA, revB, results)
+}
+
+func splitRevs(spec string) (revA, revB string) {
+	parts := strings.SplitN(spec, "..", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		log.Fatalf("-compare expects the form revA..revB, got %q", spec)
+	}
+	return parts[0], parts[1]
+}
+
+func sanitizeRev(rev string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(rev)
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, bytes.TrimSpace(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runIsolatedInGopath runs one benchmark *oCount times with GOPATH pointed at
+// gopath, so the go tool builds and tests the rev-specific worktree rather
+// than whatever is on the real GOPATH. It collects -artifacts, if requested,
+// and returns the parsed samples, dropping iterations it cannot parse.
+func runIsolatedInGopath(name, importPath, gopath, rev string) []*parse.Benchmark {
+	var samples []*parse.Benchmark
+	first := true
+	for i := 0; i < *oCount; i++ {
+		if !first {
+			cooldown()
+		}
+		first = false
+
+		args := []string{"test", "-run", "NONE", "-bench", fmt.Sprintf("^%s$", name), importPath}
+		if *oBenchmem {
+			args = append(args, "-benchmem")
+		}
+		if *oArtifacts != "" {
+			dir := filepath.Join(*oArtifacts, rev)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatal(err)
+			}
+			args = append(args,
+				"-cpuprofile", filepath.Join(dir, name+".cpu"),
+				"-memprofile", filepath.Join(dir, name+".mem"),
+				"-trace", filepath.Join(dir, name+".trace"),
+			)
+		}
+
+		// GO111MODULE=off forces GOPATH-mode resolution of the worktree
+		// checked out under gopath/src/importPath; without it, a Go 1.16+
+		// toolchain (GO111MODULE=on by default) fails to find the package
+		// since the worktree isn't itself a module.
+		cmd := buildCmd(args, "GOPATH="+gopath, "GO111MODULE=off")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("rev %s, iteration %d/%d of %s: %s", rev, i+1, *oCount, name, err)
+			continue
+		}
+
+		var line []byte
+		for _, l := range bytes.Split(out, []byte{'\n'}) {
+			if len(l) != 0 && !isBenchHeaderLine(l) {
+				line = l
+				break
+			}
+		}
+		if line == nil {
+			continue
+		}
+
+		b, err := parse.ParseLine(string(line))
+		if err != nil {
+			log.Printf("rev %s, iteration %d/%d of %s: %s", rev, i+1, *oCount, name, err)
+			continue
+		}
+		samples = append(samples, b)
+	}
+	return samples
+}
+
+func printCompareTable(bench []string, revA, revB string, results map[string]map[string][]*parse.Benchmark) {
+	fmt.Printf("benchmark%46sold ns/op%15snew ns/op%13sdelta\n", "", "", "")
+	for _, name := range bench {
+		old := results[name][revA]
+		cur := results[name][revB]
+		if len(old) == 0 || len(cur) == 0 {
+			fmt.Printf("%-50s no result\n", name)
+			continue
+		}
+
+		oldMean := computeStat(nsPerOpValues(old)).mean
+		curMean := computeStat(nsPerOpValues(cur)).mean
+		var delta float64
+		if oldMean != 0 {
+			delta = (curMean - oldMean) / oldMean * 100
+		}
+
+		sig := ""
+		if len(old) >= 2 && len(cur) >= 2 {
+			t, df := welchT(nsPerOpValues(old), nsPerOpValues(cur))
+			if significant(t, df) {
+				sig = "  *"
+			}
+		}
+		fmt.Printf("%-50s %-13.0f %-13.0f %+.2f%%%s\n", name, oldMean, curMean, delta, sig)
+	}
+}
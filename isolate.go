@@ -0,0 +1,68 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+var (
+	oPin      = flag.String("pin", "", "Pin the child 'go test' process to this cpulist (taskset -c on Linux, cpuset on FreeBSD).")
+	oNice     = flag.Int("nice", 0, "Run the child 'go test' process under this nice(1) adjustment.")
+	oCooldown = flag.Duration("cooldown", 2*time.Second, "Sleep this long between isolated benchmark invocations.")
+	oWarmup   = flag.Int("warmup", 0, "Discard the parsed results of the first n iterations of each benchmark as warmup.")
+	oGCOff    = flag.Bool("gc-off", false, "Set GOGC=off for the child process; the tool forces a runtime.GC between its own invocations instead.")
+	oCPU      = flag.String("cpu", "", "Comma-separated GOMAXPROCS values, passed through to 'go test -cpu' to sweep in one invocation.")
+)
+
+// buildCmd assembles the exec.Cmd for one 'go test' invocation, wrapping it
+// with taskset/cpuset and nice as requested by -pin/-nice, and setting
+// GOGC=off in its environment when -gc-off is given. extraEnv, if any, is
+// appended on top of the inherited environment (e.g. a per-revision GOPATH).
+func buildCmd(goArgs []string, extraEnv ...string) *exec.Cmd {
+	if *oCPU != "" {
+		goArgs = append(goArgs, "-cpu", *oCPU)
+	}
+
+	argv := append([]string{"go"}, goArgs...)
+	if *oPin != "" {
+		switch runtime.GOOS {
+		case "linux":
+			argv = append([]string{"taskset", "-c", *oPin}, argv...)
+		case "freebsd":
+			argv = append([]string{"cpuset", "-l", *oPin}, argv...)
+		default:
+			log.Printf("-pin is not supported on %s; ignoring", runtime.GOOS)
+		}
+	}
+	if *oNice != 0 {
+		argv = append([]string{"nice", "-n", strconv.Itoa(*oNice)}, argv...)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), extraEnv...)
+	if *oGCOff {
+		cmd.Env = append(cmd.Env, "GOGC=off")
+	}
+	return cmd
+}
+
+// cooldown sleeps for -cooldown and, when -gc-off asked the child processes
+// to stop collecting, forces a GC in the bench tool's own process so its
+// resident memory doesn't grow unchecked across a long sweep.
+func cooldown() {
+	if *oGCOff {
+		runtime.GC()
+	}
+	if *oCooldown > 0 {
+		time.Sleep(*oCooldown)
+	}
+}
@@ -10,7 +10,42 @@
 //
 // Usage:
 //
-//	bench [-benchmem] [import-path]
+//	bench [-benchmem] [-count n] [-benchtime d] [-cv pct] [-baseline path] [import-path]
+//
+// -count repeats every isolated benchmark n times and, when n > 1, appends a
+// SUMMARY block with the mean, median, min, max and sample standard deviation
+// of ns/op, MB/s, B/op and allocs/op, flagging any benchmark whose ns/op
+// coefficient of variation exceeds -cv (default 5%). -baseline compares the
+// run against a prior run's raw output (as saved from this tool) using
+// Welch's t-test. Iterations whose output fails to parse are noted and
+// dropped rather than aborting the run.
+//
+// -pin, -nice, -cooldown, -warmup, -gc-off and -cpu quiet the machine around
+// each isolated invocation: -pin and -nice wrap the child 'go test' process
+// (taskset/cpuset and nice(1)), -cooldown sleeps between invocations,
+// -warmup discards the first iterations of each benchmark from the summary,
+// -gc-off sets GOGC=off for the child, and -cpu sweeps GOMAXPROCS via
+// 'go test -cpu' in a single invocation.
+//
+// -compare revA..revB resolves its single package the same module-aware or
+// GOPATH way as a normal run, then checks out both revisions into scratch
+// git worktrees (under -workdir) and prints a benchcmp-style delta table
+// between them directly, optionally saving -cpuprofile/-memprofile/-trace
+// artifacts for each run under -artifacts.
+//
+// Inside a module (a go.mod at or above the working directory), import
+// paths are resolved with 'go list -json -test', so bench also accepts
+// multiple packages or patterns like ./... and no longer needs GOPATH.
+// Outside a module it falls back to the original GOPATH-only resolution,
+// which accepts at most one import path.
+//
+// -format {text,json,ndjson} selects the output: text is the original
+// column-aligned, benchcmp-compatible output; json emits one document per
+// package with all of its runs once that package's benchmarks finish, so a
+// multi-package invocation prints several documents; ndjson streams one JSON
+// object per line as each subprocess completes, so downstream tools don't
+// have to regex-scrape the text format. -count's SUMMARY block and
+// -baseline's comparison are text-only.
 //
 // Purpose
 //
@@ -59,14 +94,18 @@ import (
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [-benchmem] [package]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [-benchmem] [-count n] [-format text|json|ndjson] [package ...|./...]\n\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 }
 
 var (
 	//TODO oBench = flag.String("bench", ".", "Regexp to select benchmarks.")
-	oBenchmem = flag.Bool("benchmem", false, "Print memory allocation statistics for benchmarks.")
+	oBenchmem  = flag.Bool("benchmem", false, "Print memory allocation statistics for benchmarks.")
+	oCount     = flag.Int("count", 1, "Run each benchmark n times and append a statistical summary.")
+	oBenchtime = flag.String("benchtime", "", "Passed through to 'go test -benchtime'.")
+	oCV        = flag.Float64("cv", 5, "Warn in the summary when a benchmark's ns/op coefficient of variation (%) exceeds this.")
+	oBaseline  = flag.String("baseline", "", "Path to a prior run's raw output; compares it against this run with Welch's t-test.")
 )
 
 func defaultTags() []string {
@@ -103,38 +142,10 @@ outer:
 	return tags
 }
 
-func main() {
-	log.SetFlags(0)
-	_, err := exec.LookPath("go")
-	if err != nil {
-		log.Fatalf("Cannot find the go tool: %s", err)
-	}
-
-	flag.Parse()
-	var importPath string
-	gopaths := filepath.SplitList(os.Getenv("GOPATH"))
-	switch {
-	case flag.NArg() == 0 || flag.NArg() == 1 && flag.Arg(0) == ".":
-		wd, err := os.Getwd()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		for _, v := range gopaths {
-			var err error
-			if importPath, err = filepath.Rel(filepath.Join(v, "src"), wd); err == nil {
-				break
-			}
-		}
-		if importPath == "" {
-			log.Fatal("Cannot determine import path of the current directory.")
-		}
-	case flag.NArg() == 1:
-		importPath = flag.Arg(0)
-	default:
-		log.Fatal("At most one import path is supported.")
-	}
-
+// discoverBenchmarks returns the names of the Benchmark* functions found in
+// the _test.go files of importPath, resolved against gopaths exactly as the
+// go tool would in GOPATH mode.
+func discoverBenchmarks(gopaths []string, importPath string) ([]string, error) {
 	ctx, err := gc.NewContext(
 		runtime.GOOS,
 		runtime.GOARCH,
@@ -143,19 +154,19 @@ func main() {
 		defaultTags(),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	_, _, testFiles, err := ctx.FilesFromImportPath(importPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	var bench [][]byte
+	var bench []string
 	for _, v := range testFiles {
 		b, err := ioutil.ReadFile(v)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		a := bytes.Split(b, []byte{'\n'})
@@ -166,89 +177,238 @@ func main() {
 
 			v = v[len("func "):]
 			v = v[:bytes.Index(v, []byte{'('})]
-			bench = append(bench, v)
+			bench = append(bench, string(v))
 		}
 	}
-	width := 0
-	for _, v := range bench {
-		width = mathutil.Max(width, len(v))
-	}
+	return bench, nil
+}
 
-	var t time.Duration
-	for _, v := range bench {
-		args := []string{"test", "-run", "NONE", "-bench", fmt.Sprintf("^%s$", v), importPath}
-		if *oBenchmem {
-			args = append(args, "-benchmem")
-		}
-		cmd := exec.Command("go", args...)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatal(err)
+// printBenchLine prints one parsed benchmark result in the tool's
+// benchcmp-compatible column format.
+func printBenchLine(b *parse.Benchmark, width int) {
+	fmt.Printf("%*s%15d", -(width + 4), b.Name, b.N)
+	if b.Measured&parse.NsPerOp != 0 {
+		s := fmt.Sprintf("%.2f", b.NsPerOp)
+		if strings.Index(s, ".") > 2 {
+			s = s[:len(s)-3]
 		}
+		fmt.Printf("%15s ns/op", s)
+	}
+	if b.Measured&parse.MBPerS != 0 {
+		fmt.Printf("%15.2f MB/s", b.MBPerS)
+	}
+	if b.Measured&parse.AllocedBytesPerOp != 0 {
+		fmt.Printf("%15v B/op", b.AllocedBytesPerOp)
+	}
+	if b.Measured&parse.AllocsPerOp != 0 {
+		fmt.Printf("%15v allocs/op", b.AllocsPerOp)
+	}
+	fmt.Println()
+}
 
-		// Inputs
-		// ------
-		// $
-		// Benchmark1-4   	    2000	   1068291 ns/op
-		// PASS
-		// ok  	github.com/cznic/bench	2.250s
-		//
-		// Benchmark2-4   	     100	  10067251 ns/op
-		// PASS
-		// ok  	github.com/cznic/bench	1.021s
-		//
-		// Collective Output
-		// ------
-		// $ go test -bench .
-		// Benchmark1-4   	    2000	   1067848 ns/op
-		// Benchmark2-4   	     100	  10066557 ns/op
-		// PASS
-		// ok  	github.com/cznic/bench	3.267s
-		// $
-
-		a := bytes.Split(out, []byte{'\n'})
-		if len(a) < 3 {
-			log.Fatalf("Unrecognized format of go test output:\n%s", out)
+// benchHeaderPrefixes are the "key: value" lines current Go toolchains print
+// before the benchmark results of every `go test -bench` run (e.g. "goos:
+// linux"), so they can be skipped rather than logged as dropped iterations.
+var benchHeaderPrefixes = [][]byte{
+	[]byte("goos: "),
+	[]byte("goarch: "),
+	[]byte("pkg: "),
+	[]byte("cpu: "),
+}
+
+func isBenchHeaderLine(line []byte) bool {
+	for _, p := range benchHeaderPrefixes {
+		if bytes.HasPrefix(line, p) {
+			return true
 		}
+	}
+	return false
+}
+
+func main() {
+	log.SetFlags(0)
+	_, err := exec.LookPath("go")
+	if err != nil {
+		log.Fatalf("Cannot find the go tool: %s", err)
+	}
+
+	flag.Parse()
+	gopaths := filepath.SplitList(os.Getenv("GOPATH"))
+
+	if *oCompare != "" {
+		runCompare(resolveSingleImportPath(flag.Args(), gopaths))
+		return
+	}
 
-		p := fmt.Sprintf("ok  \t%s\t", importPath)
-		if !bytes.HasPrefix(a[0], v) ||
-			!bytes.Equal(a[1], []byte("PASS")) ||
-			!bytes.HasPrefix(a[2], []byte(p)) {
-			log.Fatalf("Unexpected format of go test output:\n%s", out)
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pkgs []pkgBench
+	if moduleRoot(wd) != "" {
+		patterns := flag.Args()
+		if len(patterns) == 0 {
+			patterns = []string{"."}
 		}
 
-		d, err := time.ParseDuration(string(a[2][len(p):]))
+		listed, err := resolvePackages(patterns)
 		if err != nil {
-			log.Fatalf("Cannot parse benchmark duration\n%s", out)
+			log.Fatal(err)
 		}
-
-		t += d
-		b, err := parse.ParseLine(string(a[0]))
+		for _, p := range realPackages(listed) {
+			names, err := benchmarksInPackage(p)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pkgs = append(pkgs, pkgBench{importPath: p.ImportPath, names: names})
+		}
+	} else {
+		importPath := resolveGopathImportPath(flag.Args(), gopaths)
+		names, err := discoverBenchmarks(gopaths, importPath)
 		if err != nil {
-			fmt.Printf("%s\n", a[0])
-			continue
+			log.Fatal(err)
 		}
+		pkgs = append(pkgs, pkgBench{importPath: importPath, names: names})
+	}
 
-		fmt.Printf("%*s%15d", -(width + 4), b.Name, b.N)
-		if b.Measured&parse.NsPerOp != 0 {
-			s := fmt.Sprintf("%.2f", b.NsPerOp)
-			if strings.Index(s, ".") > 2 {
-				s = s[:len(s)-3]
-			}
-			fmt.Printf("%15s ns/op", s)
+	for i, pkg := range pkgs {
+		if i > 0 {
+			cooldown()
 		}
-		if b.Measured&parse.MBPerS != 0 {
-			fmt.Printf("%15.2f MB/s", b.MBPerS)
+		runPackage(pkg.importPath, pkg.names)
+	}
+}
+
+// runPackage runs every named benchmark of importPath in isolation and
+// reports the results; it is the tool's original single-package workflow,
+// now invoked once per package matched on the command line.
+func runPackage(importPath string, bench []string) {
+	width := 0
+	for _, v := range bench {
+		width = mathutil.Max(width, len(v))
+	}
+
+	rep := newReporter(*oFormat)
+	if tr, ok := rep.(*textReporter); ok {
+		tr.width = width
+	}
+	rep.Start(importPath)
+
+	var t time.Duration
+	var order []string
+	results := make(map[string][]*parse.Benchmark)
+	first := true
+	for _, name := range bench {
+		for i := 0; i < *oCount; i++ {
+			if !first {
+				cooldown()
+			}
+			first = false
+
+			args := []string{"test", "-run", "NONE", "-bench", fmt.Sprintf("^%s$", name), importPath}
+			if *oBenchmem {
+				args = append(args, "-benchmem")
+			}
+			if *oBenchtime != "" {
+				args = append(args, "-benchtime", *oBenchtime)
+			}
+			cmd := buildCmd(args)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				log.Printf("dropping failed iteration %d/%d of %s: %s", i+1, *oCount, name, err)
+				continue
+			}
+
+			// Inputs
+			// ------
+			// $
+			// Benchmark1-4   	    2000	   1068291 ns/op
+			// PASS
+			// ok  	github.com/cznic/bench	2.250s
+			//
+			// Benchmark2-4   	     100	  10067251 ns/op
+			// PASS
+			// ok  	github.com/cznic/bench	1.021s
+			//
+			// Collective Output
+			// ------
+			// $ go test -bench .
+			// Benchmark1-4   	    2000	   1067848 ns/op
+			// Benchmark2-4   	     100	  10066557 ns/op
+			// PASS
+			// ok  	github.com/cznic/bench	3.267s
+			// $
+			//
+			// With -cpu=1,2,4 (or any other multi-value list), one result
+			// line per GOMAXPROCS value precedes the PASS line.
+
+			a := bytes.Split(out, []byte{'\n'})
+			passIdx := -1
+			for idx, line := range a {
+				if bytes.Equal(line, []byte("PASS")) {
+					passIdx = idx
+					break
+				}
+			}
+			if passIdx < 1 || passIdx+1 >= len(a) {
+				log.Fatalf("Unrecognized format of go test output:\n%s", out)
+			}
+
+			p := fmt.Sprintf("ok  \t%s\t", importPath)
+			if !bytes.HasPrefix(a[passIdx+1], []byte(p)) {
+				log.Fatalf("Unexpected format of go test output:\n%s", out)
+			}
+
+			d, err := time.ParseDuration(string(a[passIdx+1][len(p):]))
+			if err != nil {
+				log.Fatalf("Cannot parse benchmark duration\n%s", out)
+			}
+			t += d
+
+			for _, line := range a[:passIdx] {
+				if len(line) == 0 || isBenchHeaderLine(line) {
+					continue
+				}
+
+				b, err := parse.ParseLine(string(line))
+				if err != nil {
+					if *oFormat == "text" {
+						fmt.Printf("%s\n", line)
+					}
+					log.Printf("dropping invalid iteration %d/%d of %s: %s", i+1, *oCount, name, err)
+					continue
+				}
+
+				rep.Result(b, string(out), d)
+				if i < *oWarmup {
+					continue
+				}
+
+				// With a multi-value -cpu, one invocation yields several
+				// GOMAXPROCS variants (distinct b.Name) of the same
+				// benchmark; key by the full parsed name so -count's
+				// SUMMARY and -baseline don't average across them as if
+				// they were repeats of the same configuration.
+				key := name
+				if strings.Contains(*oCPU, ",") {
+					key = b.Name
+				}
+				if _, ok := results[key]; !ok {
+					order = append(order, key)
+				}
+				results[key] = append(results[key], b)
+			}
 		}
-		if b.Measured&parse.AllocedBytesPerOp != 0 {
-			fmt.Printf("%15v B/op", b.AllocedBytesPerOp)
+	}
+	rep.End(t)
+
+	if *oFormat == "text" {
+		if *oCount > 1 {
+			printSummary(order, results, width, *oCV)
 		}
-		if b.Measured&parse.AllocsPerOp != 0 {
-			fmt.Printf("%15v allocs/op", b.AllocsPerOp)
+		if *oBaseline != "" {
+			printBaselineComparison(*oBaseline, order, results)
 		}
-		fmt.Println()
 	}
-	fmt.Printf("PASS\n")
-	fmt.Printf("ok  \t%s\t%v\n", importPath, t)
 }
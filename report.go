@@ -0,0 +1,141 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/tools/benchmark/parse"
+)
+
+var oFormat = flag.String("format", "text", "Output format: text, json or ndjson.")
+
+// reporter receives the results of a run as they happen, so output formats
+// that must stream (ndjson) never need to buffer to the end.
+type reporter interface {
+	Start(importPath string)
+	Result(b *parse.Benchmark, raw string, elapsed time.Duration)
+	End(total time.Duration)
+}
+
+func newReporter(format string) reporter {
+	switch format {
+	case "text":
+		return &textReporter{}
+	case "json":
+		return &jsonReporter{}
+	case "ndjson":
+		return &ndjsonReporter{}
+	default:
+		log.Fatalf("unknown -format %q, want text, json or ndjson", format)
+		panic("unreachable")
+	}
+}
+
+// textReporter preserves the tool's original, benchcmp-compatible
+// column-aligned output. width must be set by the caller before the first
+// Result call.
+type textReporter struct {
+	importPath string
+	width      int
+}
+
+func (r *textReporter) Start(importPath string) { r.importPath = importPath }
+
+func (r *textReporter) Result(b *parse.Benchmark, raw string, elapsed time.Duration) {
+	printBenchLine(b, r.width)
+}
+
+func (r *textReporter) End(total time.Duration) {
+	fmt.Printf("PASS\n")
+	fmt.Printf("ok  \t%s\t%v\n", r.importPath, total)
+}
+
+type jsonRun struct {
+	Name              string  `json:"name"`
+	N                 int     `json:"n"`
+	NsPerOp           float64 `json:"ns_per_op,omitempty"`
+	MBPerS            float64 `json:"mb_per_s,omitempty"`
+	AllocedBytesPerOp uint64  `json:"alloc_bytes_per_op,omitempty"`
+	AllocsPerOp       uint64  `json:"allocs_per_op,omitempty"`
+	RawStdout         string  `json:"raw_stdout"`
+}
+
+// jsonReporter buffers every run and emits a single JSON document at End.
+type jsonReporter struct {
+	importPath string
+	runs       []jsonRun
+}
+
+func (r *jsonReporter) Start(importPath string) { r.importPath = importPath }
+
+func (r *jsonReporter) Result(b *parse.Benchmark, raw string, elapsed time.Duration) {
+	r.runs = append(r.runs, benchmarkToRun(b, raw))
+}
+
+func (r *jsonReporter) End(total time.Duration) {
+	doc := struct {
+		ImportPath    string    `json:"importPath"`
+		GoVersion     string    `json:"goVersion"`
+		Tags          []string  `json:"tags"`
+		TotalDuration string    `json:"totalDuration"`
+		Runs          []jsonRun `json:"runs"`
+	}{
+		ImportPath:    r.importPath,
+		GoVersion:     runtime.Version(),
+		Tags:          defaultTags(),
+		TotalDuration: total.String(),
+		Runs:          r.runs,
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ndjsonReporter streams one JSON object per line: a header as soon as the
+// run starts, one object per completed benchmark, and a trailer at the end.
+type ndjsonReporter struct {
+	enc *json.Encoder
+}
+
+func (r *ndjsonReporter) Start(importPath string) {
+	r.enc = json.NewEncoder(os.Stdout)
+	r.enc.Encode(struct {
+		ImportPath string   `json:"importPath"`
+		GoVersion  string   `json:"goVersion"`
+		Tags       []string `json:"tags"`
+	}{importPath, runtime.Version(), defaultTags()})
+}
+
+func (r *ndjsonReporter) Result(b *parse.Benchmark, raw string, elapsed time.Duration) {
+	r.enc.Encode(benchmarkToRun(b, raw))
+}
+
+func (r *ndjsonReporter) End(total time.Duration) {
+	r.enc.Encode(struct {
+		TotalDuration string `json:"totalDuration"`
+	}{total.String()})
+}
+
+func benchmarkToRun(b *parse.Benchmark, raw string) jsonRun {
+	return jsonRun{
+		Name:              b.Name,
+		N:                 b.N,
+		NsPerOp:           b.NsPerOp,
+		MBPerS:            b.MBPerS,
+		AllocedBytesPerOp: b.AllocedBytesPerOp,
+		AllocsPerOp:       b.AllocsPerOp,
+		RawStdout:         raw,
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// pkgBench is one package resolved from the command line, together with the
+// Benchmark* functions found in it.
+type pkgBench struct {
+	importPath string
+	names      []string
+}
+
+// goListPackage is the subset of `go list -json -test` output this tool
+// needs to find a package's test files without compiling anything.
+type goListPackage struct {
+	ImportPath   string
+	Dir          string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+// moduleRoot walks up from dir looking for a go.mod, returning its directory
+// or "" if dir is not inside a module (i.e. plain GOPATH mode).
+func moduleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// resolvePackages expands import path patterns, including "./..." and other
+// go list patterns, into concrete packages via 'go list -json -test'. This
+// is what lets the tool run on modules without depending on
+// github.com/cznic/gc, which only understands GOPATH layouts.
+func resolvePackages(patterns []string) ([]goListPackage, error) {
+	args := append([]string{"list", "-json", "-test"}, patterns...)
+	cmd := exec.Command("go", args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go %s: %s", args, err)
+	}
+
+	var pkgs []goListPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p goListPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// realPackages filters out the synthetic packages -test makes 'go list'
+// emit alongside the real one: the "<path>.test" generated test-binary main
+// package and the "<path> [<path>.test]" test-variant build of the package
+// itself. Neither is an import path the go tool accepts on its own, so
+// callers that pass resolvePackages' output to another go command or expect
+// it to name exactly one real package need to filter them first.
+func realPackages(listed []goListPackage) []goListPackage {
+	var pkgs []goListPackage
+	for _, p := range listed {
+		if strings.HasSuffix(p.ImportPath, ".test") || strings.ContainsAny(p.ImportPath, "[]") {
+			continue
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// benchmarksInPackage scans the test files go list resolved for pkg and
+// returns the names of its Benchmark* functions.
+func benchmarksInPackage(pkg goListPackage) ([]string, error) {
+	var bench []string
+	files := append(append([]string{}, pkg.TestGoFiles...), pkg.XTestGoFiles...)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(pkg.Dir, f))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range bytes.Split(b, []byte{'\n'}) {
+			if !bytes.HasPrefix(line, []byte("func Benchmark")) {
+				continue
+			}
+
+			line = line[len("func "):]
+			line = line[:bytes.Index(line, []byte{'('})]
+			bench = append(bench, string(line))
+		}
+	}
+	return bench, nil
+}
+
+// resolveSingleImportPath resolves patterns to exactly one package's import
+// path, going through the module-aware resolvePackages when the working
+// directory is inside a module and falling back to the original GOPATH-only
+// resolution otherwise. It is used by -compare, which operates on a single
+// package.
+func resolveSingleImportPath(patterns []string, gopaths []string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if moduleRoot(wd) == "" {
+		return resolveGopathImportPath(patterns, gopaths)
+	}
+
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	listed, err := resolvePackages(patterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	real := realPackages(listed)
+	if len(real) != 1 {
+		log.Fatalf("-compare requires exactly one package, got %d matching %v", len(real), patterns)
+	}
+	return real[0].ImportPath
+}
+
+// resolveGopathImportPath reproduces the tool's original GOPATH-only
+// argument handling, used as a fallback outside of module mode: no
+// arguments (or a lone ".") means the import path of the working directory,
+// exactly one argument is taken as the import path, anything else is an
+// error.
+func resolveGopathImportPath(patterns []string, gopaths []string) string {
+	switch {
+	case len(patterns) == 0 || len(patterns) == 1 && patterns[0] == ".":
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var importPath string
+		for _, v := range gopaths {
+			var err error
+			if importPath, err = filepath.Rel(filepath.Join(v, "src"), wd); err == nil {
+				break
+			}
+		}
+		if importPath == "" {
+			log.Fatal("Cannot determine import path of the current directory.")
+		}
+		return importPath
+	case len(patterns) == 1:
+		return patterns[0]
+	default:
+		log.Fatal("At most one import path is supported outside module mode; run inside a module to use multiple paths or ./...")
+		panic("unreachable")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/tools/benchmark/parse"
+)
+
+// stat holds the summary statistics of a single metric (ns/op, MB/s, ...)
+// computed over the -count samples of one benchmark.
+type stat struct {
+	mean, median, min, max, stddev, cv float64
+}
+
+func computeStat(xs []float64) stat {
+	n := len(xs)
+	if n == 0 {
+		return stat{}
+	}
+
+	var sum float64
+	mn, mx := xs[0], xs[0]
+	for _, x := range xs {
+		sum += x
+		mn = math.Min(mn, x)
+		mx = math.Max(mx, x)
+	}
+	mean := sum / float64(n)
+
+	var sqDiff float64
+	for _, x := range xs {
+		d := x - mean
+		sqDiff += d * d
+	}
+	var stddev float64
+	if n > 1 {
+		stddev = math.Sqrt(sqDiff / float64(n-1))
+	}
+
+	sorted := append([]float64(nil), xs...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j-1] > sorted[j]; j-- {
+			sorted[j-1], sorted[j] = sorted[j], sorted[j-1]
+		}
+	}
+	var median float64
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	} else {
+		median = sorted[n/2]
+	}
+
+	var cv float64
+	if mean != 0 {
+		cv = 100 * stddev / mean
+	}
+	return stat{mean: mean, median: median, min: mn, max: mx, stddev: stddev, cv: cv}
+}
+
+// benchSummary is the aggregate of all samples collected for one benchmark
+// name across -count iterations.
+type benchSummary struct {
+	name              string
+	n                 int
+	measured          int
+	nsPerOp           stat
+	mbPerS            stat
+	allocedBytesPerOp stat
+	allocsPerOp       stat
+}
+
+func summarize(name string, samples []*parse.Benchmark) benchSummary {
+	s := benchSummary{name: name, n: len(samples)}
+	if len(samples) == 0 {
+		return s
+	}
+
+	var ns, mb, ab, ao []float64
+	for _, b := range samples {
+		s.measured |= b.Measured
+		ns = append(ns, b.NsPerOp)
+		mb = append(mb, b.MBPerS)
+		ab = append(ab, float64(b.AllocedBytesPerOp))
+		ao = append(ao, float64(b.AllocsPerOp))
+	}
+	s.nsPerOp = computeStat(ns)
+	s.mbPerS = computeStat(mb)
+	s.allocedBytesPerOp = computeStat(ab)
+	s.allocsPerOp = computeStat(ao)
+	return s
+}
+
+// printSummary writes the "SUMMARY" block following the per-run output,
+// one line of statistics per metric actually measured, in the order the
+// benchmarks were first seen.
+func printSummary(order []string, results map[string][]*parse.Benchmark, width int, cvThreshold float64) {
+	fmt.Printf("\nSUMMARY\n")
+	for _, name := range order {
+		samples := results[name]
+		if len(samples) == 0 {
+			continue
+		}
+
+		s := summarize(name, samples)
+		fmt.Printf("%*s%6d samples\n", -(width + 4), s.name, s.n)
+		if s.measured&parse.NsPerOp != 0 {
+			printStatLine("ns/op", s.nsPerOp, cvThreshold)
+		}
+		if s.measured&parse.MBPerS != 0 {
+			printStatLine("MB/s", s.mbPerS, cvThreshold)
+		}
+		if s.measured&parse.AllocedBytesPerOp != 0 {
+			printStatLine("B/op", s.allocedBytesPerOp, cvThreshold)
+		}
+		if s.measured&parse.AllocsPerOp != 0 {
+			printStatLine("allocs/op", s.allocsPerOp, cvThreshold)
+		}
+	}
+}
+
+func printStatLine(unit string, s stat, cvThreshold float64) {
+	fmt.Printf("    %-10s mean=%.2f median=%.2f min=%.2f max=%.2f stddev=%.2f cv=%.2f%%",
+		unit, s.mean, s.median, s.min, s.max, s.stddev, s.cv)
+	if unit == "ns/op" && s.cv > cvThreshold {
+		fmt.Printf("  WARNING: cv exceeds %.2f%%", cvThreshold)
+	}
+	fmt.Println()
+}
+
+// baseBenchmarkName strips the "-N" GOMAXPROCS suffix go test appends to a
+// benchmark's printed name (e.g. "BenchmarkFoo-8" -> "BenchmarkFoo"), so
+// samples can be matched against the bare function name used elsewhere in
+// this tool.
+func baseBenchmarkName(name string) string {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 || i == len(name)-1 {
+		return name
+	}
+
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return name
+		}
+	}
+	return name[:i]
+}
+
+// parseBaseline reads a prior run's raw, benchcmp-compatible output (as
+// produced by this tool without -format) and groups the parsed samples by
+// their bare benchmark name, stripping the GOMAXPROCS suffix that
+// parse.ParseSet's own grouping keeps, so the result matches the keys used
+// for the current run's results. With a multi-value -cpu the current run is
+// keyed by the full printed name, so the suffix is kept here as well.
+func parseBaseline(path string) (map[string][]*parse.Benchmark, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set, err := parse.ParseSet(f)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string][]*parse.Benchmark, len(set))
+	for name, benchmarks := range set {
+		key := name
+		if !strings.Contains(*oCPU, ",") {
+			key = baseBenchmarkName(name)
+		}
+		m[key] = append(m[key], benchmarks...)
+	}
+	return m, nil
+}
+
+// welchT returns Welch's t statistic and the associated (Welch-Satterthwaite)
+// degrees of freedom for two independent samples of unequal variance.
+func welchT(a, b []float64) (t, df float64) {
+	if len(a) < 2 || len(b) < 2 {
+		return 0, 0
+	}
+
+	sa, sb := computeStat(a), computeStat(b)
+	va, vb := sa.stddev*sa.stddev, sb.stddev*sb.stddev
+	na, nb := float64(len(a)), float64(len(b))
+
+	se2 := va/na + vb/nb
+	if se2 == 0 {
+		return 0, 0
+	}
+
+	t = (sa.mean - sb.mean) / math.Sqrt(se2)
+	num := se2 * se2
+	den := (va*va)/(na*na*(na-1)) + (vb*vb)/(nb*nb*(nb-1))
+	if den != 0 {
+		df = num / den
+	}
+	return t, df
+}
+
+// significant reports whether t is large enough, for the given degrees of
+// freedom, to call the two means different at roughly the 5% level. It uses
+// the large-sample normal approximation (critical value 1.96) for df >= 30
+// and a slightly more conservative fixed threshold otherwise; it is meant as
+// a quick signal, not a substitute for a full t-distribution table.
+func significant(t, df float64) bool {
+	critical := 2.04
+	if df >= 30 {
+		critical = 1.96
+	}
+	return math.Abs(t) > critical
+}
+
+// printBaselineComparison prints a Welch's t-test of ns/op between the
+// baseline run loaded from path and the results just collected.
+func printBaselineComparison(path string, order []string, results map[string][]*parse.Benchmark) {
+	baseline, err := parseBaseline(path)
+	if err != nil {
+		log.Printf("skipping -baseline comparison: %s", err)
+		return
+	}
+
+	fmt.Printf("\nBASELINE COMPARISON (Welch's t-test, ns/op, %s vs current)\n", path)
+	for _, name := range order {
+		old, ok := baseline[name]
+		if !ok {
+			continue
+		}
+
+		cur := results[name]
+		if len(old) < 2 || len(cur) < 2 {
+			fmt.Printf("%-30s not enough samples for a t-test\n", name)
+			continue
+		}
+
+		oldNs := nsPerOpValues(old)
+		curNs := nsPerOpValues(cur)
+		t, df := welchT(oldNs, curNs)
+		verdict := "no significant difference"
+		if significant(t, df) {
+			verdict = "significant difference"
+		}
+		fmt.Printf("%-30s t=%.3f df=%.1f %s\n", name, t, df, verdict)
+	}
+}
+
+func nsPerOpValues(samples []*parse.Benchmark) []float64 {
+	xs := make([]float64, len(samples))
+	for i, b := range samples {
+		xs[i] = b.NsPerOp
+	}
+	return xs
+}
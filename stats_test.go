@@ -0,0 +1,64 @@
+// Copyright 2016 The Bench Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestComputeStat(t *testing.T) {
+	s := computeStat([]float64{1, 2, 3, 4, 5})
+	if s.mean != 3 {
+		t.Errorf("mean = %v, want 3", s.mean)
+	}
+	if s.median != 3 {
+		t.Errorf("median = %v, want 3", s.median)
+	}
+	if s.min != 1 {
+		t.Errorf("min = %v, want 1", s.min)
+	}
+	if s.max != 5 {
+		t.Errorf("max = %v, want 5", s.max)
+	}
+
+	if empty := computeStat(nil); empty != (stat{}) {
+		t.Errorf("computeStat(nil) = %+v, want zero value", empty)
+	}
+}
+
+func TestWelchT(t *testing.T) {
+	a := []float64{10, 11, 9, 10, 10}
+	b := []float64{20, 21, 19, 20, 20}
+	tt, df := welchT(a, b)
+	if tt >= 0 {
+		t.Errorf("t = %v, want negative (a's mean is below b's)", tt)
+	}
+	if !significant(tt, df) {
+		t.Errorf("significant(%v, %v) = false, want true for well-separated samples", tt, df)
+	}
+
+	same := []float64{10, 11, 9, 10, 10}
+	tt, df = welchT(a, same)
+	if significant(tt, df) {
+		t.Errorf("significant(%v, %v) = true, want false for identical samples", tt, df)
+	}
+
+	if tt, df := welchT([]float64{1}, []float64{1, 2}); tt != 0 || df != 0 {
+		t.Errorf("welchT with <2 samples = (%v, %v), want (0, 0)", tt, df)
+	}
+}
+
+func TestBaseBenchmarkName(t *testing.T) {
+	cases := map[string]string{
+		"BenchmarkFoo-8": "BenchmarkFoo",
+		"BenchmarkFoo-1": "BenchmarkFoo",
+		"BenchmarkFoo":   "BenchmarkFoo",
+		"BenchmarkFoo-":  "BenchmarkFoo-",
+		"Benchmark-Foo":  "Benchmark-Foo",
+	}
+	for in, want := range cases {
+		if got := baseBenchmarkName(in); got != want {
+			t.Errorf("baseBenchmarkName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}